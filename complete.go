@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// shellBuiltins lists the builtins worth completing as commands; it isn't
+// exhaustive, just the ones a pipeline stage commonly starts with.
+var shellBuiltins = []string{
+	"alias", "bg", "cd", "echo", "eval", "exec", "exit", "export", "fg",
+	"jobs", "kill", "pwd", "read", "return", "set", "shift", "source",
+	"test", "trap", "true", "false", "type", "unalias", "unset", "wait",
+}
+
+// completer answers Tab-completion queries for the input loop: command
+// names (builtins and everything on $PATH) for the first token of a
+// pipeline stage, and filenames otherwise.
+type completer struct {
+	commands []string
+}
+
+func newCompleter() *completer {
+	c := &completer{commands: append([]string{}, shellBuiltins...)}
+	seen := make(map[string]bool, len(c.commands))
+	for _, name := range c.commands {
+		seen[name] = true
+	}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, fi := range entries {
+			if fi.IsDir() || seen[fi.Name()] || fi.Mode()&0111 == 0 {
+				continue
+			}
+			seen[fi.Name()] = true
+			c.commands = append(c.commands, fi.Name())
+		}
+	}
+	sort.Strings(c.commands)
+	return c
+}
+
+// complete returns the sorted completions for the token ending at cursor
+// in buf, and the offset that token starts at.
+func (c *completer) complete(buf string, cursor int) ([]string, int) {
+	start, token := currentToken(buf, cursor)
+	if commandStart(buf, start) {
+		return c.completeCommands(token), start
+	}
+	return completeFilenames(token), start
+}
+
+func (c *completer) completeCommands(prefix string) []string {
+	var matches []string
+	for _, name := range c.commands {
+		if strings.HasPrefix(name, prefix) {
+			matches = append(matches, name)
+		}
+	}
+	return matches
+}
+
+func completeFilenames(prefix string) []string {
+	matches, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return nil
+	}
+	for i, m := range matches {
+		if fi, err := os.Stat(m); err == nil && fi.IsDir() {
+			matches[i] = m + "/"
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// isWordBreak reports whether b separates pipeline tokens: whitespace or a
+// stage separator.
+func isWordBreak(b byte) bool {
+	switch b {
+	case ' ', '\t', '|', '&', ';':
+		return true
+	}
+	return false
+}
+
+// currentToken returns the start offset and text of the token ending at
+// cursor in buf.
+func currentToken(buf string, cursor int) (start int, token string) {
+	start = cursor
+	for start > 0 && !isWordBreak(buf[start-1]) {
+		start--
+	}
+	return start, buf[start:cursor]
+}
+
+// commandStart reports whether the token beginning at idx in buf is the
+// first token of a pipeline stage: preceded only by whitespace back to the
+// start of the line, or to a `|`, `&&` or `;` separator.
+func commandStart(buf string, idx int) bool {
+	i := idx
+	for i > 0 && buf[i-1] == ' ' {
+		i--
+	}
+	switch {
+	case i == 0:
+		return true
+	case buf[i-1] == '|' || buf[i-1] == ';':
+		return true
+	case i >= 2 && buf[i-2:i] == "&&":
+		return true
+	default:
+		return false
+	}
+}
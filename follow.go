@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// lineRing is a ring buffer bounded by line count rather than byte count,
+// used for a -follow child's stdout so a long-lived log stream doesn't
+// grow pipeline's memory without bound the way a plain byte buffer would.
+type lineRing struct {
+	sync.Mutex
+	lines []string
+	max   int
+	dirty bool
+}
+
+func newLineRing(max int) *lineRing {
+	return &lineRing{max: max}
+}
+
+func (r *lineRing) Append(line string) {
+	r.Lock()
+	defer r.Unlock()
+	r.lines = append(r.lines, line)
+	if overage := len(r.lines) - r.max; overage > 0 {
+		r.lines = r.lines[overage:]
+	}
+	r.dirty = true
+}
+
+func (r *lineRing) String() string {
+	r.Lock()
+	defer r.Unlock()
+	return strings.Join(r.lines, "\n")
+}
+
+func (r *lineRing) Dirty() bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.dirty
+}
+
+func (r *lineRing) Clean() {
+	r.Lock()
+	defer r.Unlock()
+	r.dirty = false
+}
+
+// followStatus is the lifecycle state of the child process a follower
+// manages, surfaced to the user as the top-left status indicator.
+type followStatus int
+
+const (
+	followStopped followStatus = iota
+	followAlive
+	followRestarting
+	followExited
+)
+
+// follower runs a pipeline's shell command in -follow mode, starting a new
+// child each time the edited line changes and keeping it alive while stdin
+// grows, streaming its stdout into a lineRing as it arrives.
+type follower struct {
+	sync.Mutex
+	shell  string
+	out    *lineRing
+	line   string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	status followStatus
+	// done is closed by the reader goroutine once it has finished reading
+	// cmd's stdout and reaped it, so restart can be sure no one is still
+	// reading from a pipe before its fds get reused by the next child.
+	done chan struct{}
+}
+
+func newFollower(shell string, out *lineRing) *follower {
+	return &follower{shell: shell, out: out}
+}
+
+// restart kills any running child and, if line is non-empty, starts a new
+// one, wiring its stdout into out line by line. It waits for the previous
+// child's reader goroutine to finish (and reap it) before starting the
+// next one, so that goroutine's in-flight reads never race the next
+// cmd.Start() reusing the same fds.
+func (f *follower) restart(line string) error {
+	f.Lock()
+	wasAlive := f.status == followAlive
+	prevDone := f.stopLocked()
+	f.line = line
+	if wasAlive {
+		f.status = followRestarting
+	}
+	if line == "" {
+		f.status = followStopped
+	}
+	f.Unlock()
+
+	if prevDone != nil {
+		<-prevDone
+	}
+	if line == "" {
+		return nil
+	}
+
+	f.Lock()
+	cmd := exec.Command(f.shell, "-c", line)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		f.status = followExited
+		f.Unlock()
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		f.status = followExited
+		f.Unlock()
+		return err
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		f.status = followExited
+		f.Unlock()
+		return err
+	}
+	done := make(chan struct{})
+	f.cmd = cmd
+	f.stdin = stdin
+	f.status = followAlive
+	f.done = done
+	f.Unlock()
+
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			f.out.Append(scanner.Text())
+		}
+		cmd.Wait()
+		f.Lock()
+		if f.cmd == cmd {
+			f.status = followExited
+		}
+		f.Unlock()
+	}()
+	return nil
+}
+
+// stopLocked kills the current child, if any, without waiting for it to be
+// reaped (the reader goroutine spawned for it does that itself once it's
+// done reading, see restart). Callers must hold f.Lock. It returns the
+// done channel of the killed child's reader goroutine, if any, so the
+// caller can wait for it outside the lock.
+func (f *follower) stopLocked() chan struct{} {
+	done := f.done
+	if f.cmd != nil && f.cmd.Process != nil {
+		f.cmd.Process.Kill()
+	}
+	f.cmd = nil
+	f.done = nil
+	if f.stdin != nil {
+		f.stdin.Close()
+	}
+	f.stdin = nil
+	return done
+}
+
+// Write forwards stdin growth to the running child, silently dropping it
+// when there is no child to forward to (e.g. before the first commit).
+func (f *follower) Write(data []byte) (int, error) {
+	f.Lock()
+	stdin := f.stdin
+	f.Unlock()
+	if stdin == nil {
+		return len(data), nil
+	}
+	stdin.Write(data)
+	return len(data), nil
+}
+
+func (f *follower) Status() followStatus {
+	f.Lock()
+	defer f.Unlock()
+	return f.status
+}
+
+var spinnerFrames = []rune{'|', '/', '-', '\\'}
+
+// followIndicator returns the top-left status character for tick, the
+// number of 10ms ticks since start, animating only while the child is
+// alive.
+func followIndicator(status followStatus, tick int) rune {
+	switch status {
+	case followAlive:
+		return spinnerFrames[(tick/5)%len(spinnerFrames)]
+	case followRestarting:
+		return '~'
+	case followExited:
+		return 'x'
+	default:
+		return '|'
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// history persists successfully-committed pipeline command lines across
+// sessions and lets the input loop walk back and forth through them.
+type history struct {
+	sync.Mutex
+	path    string
+	entries []string
+	pos     int
+}
+
+// historyPath returns the file history is loaded from and appended to,
+// following the same XDG-or-home fallback other dotfile-ish tools use.
+func historyPath() string {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "pipeline", "history")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".pipeline_history"
+	}
+	return filepath.Join(home, ".pipeline_history")
+}
+
+func newHistory() *history {
+	h := &history{path: historyPath()}
+	h.load()
+	h.pos = len(h.entries)
+	return h
+}
+
+func (h *history) load() {
+	data, err := ioutil.ReadFile(h.path)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			h.entries = append(h.entries, line)
+		}
+	}
+}
+
+// add records line as the most recent command, both in memory and on disk,
+// unless it's empty or a repeat of the last entry.
+func (h *history) add(line string) {
+	h.Lock()
+	defer h.Unlock()
+	if line == "" || (len(h.entries) > 0 && h.entries[len(h.entries)-1] == line) {
+		h.pos = len(h.entries)
+		return
+	}
+	h.entries = append(h.entries, line)
+	h.pos = len(h.entries)
+
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		log.Printf("history: could not create %s: %v", filepath.Dir(h.path), err)
+		return
+	}
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("history: could not open %s: %v", h.path, err)
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+// prev walks one step further into the past, returning current unchanged
+// once the oldest entry has been reached.
+func (h *history) prev(current string) string {
+	h.Lock()
+	defer h.Unlock()
+	if h.pos == 0 {
+		return current
+	}
+	h.pos--
+	return h.entries[h.pos]
+}
+
+// next walks one step back towards the present, returning "" once past the
+// most recent entry, mirroring a shell's history-down-past-the-end behavior.
+func (h *history) next(current string) string {
+	h.Lock()
+	defer h.Unlock()
+	if h.pos >= len(h.entries) {
+		return ""
+	}
+	h.pos++
+	if h.pos >= len(h.entries) {
+		return ""
+	}
+	return h.entries[h.pos]
+}
+
+func (h *history) reset() {
+	h.Lock()
+	defer h.Unlock()
+	h.pos = len(h.entries)
+}
+
+// searchBack looks backward from index from (inclusive) for an entry
+// containing query, returning the match and the index it was found at.
+func (h *history) searchBack(query string, from int) (match string, idx int, ok bool) {
+	h.Lock()
+	defer h.Unlock()
+	if query == "" || from < 0 {
+		return "", from, false
+	}
+	for i := from; i >= 0; i-- {
+		if strings.Contains(h.entries[i], query) {
+			return h.entries[i], i, true
+		}
+	}
+	return "", from, false
+}
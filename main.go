@@ -8,7 +8,6 @@ import (
 	"io/ioutil"
 	logpkg "log"
 	"os"
-	"os/exec"
 	"strings"
 	"sync"
 	"time"
@@ -23,9 +22,12 @@ const TabWidth = 4
 var log *logpkg.Logger
 
 var (
-	logFile    = flag.String("log", "", "File for log writing.")
-	shell      = flag.String("shell", "bash", "Shell to use for pipeline evaluation.")
-	buffersize = flag.Int("buffersize", 16384, "Maximum size of input buffer.")
+	logFile     = flag.String("log", "", "File for log writing.")
+	shell       = flag.String("shell", "bash", "Shell to use for pipeline evaluation.")
+	buffersize  = flag.Int("buffersize", 16384, "Maximum size of input buffer.")
+	oncommit    = flag.String("oncommit", "", "File to append each Enter-committed pipeline to, with a timestamp.")
+	follow      = flag.Bool("follow", false, "Keep the shell command running as stdin grows (restarting it only when the pipeline is edited), streaming stdin to it and its stdout into view (for tail -f, kubectl logs -f, etc).")
+	followlines = flag.Int("followlines", 10000, "Lines of -follow child stdout to keep.")
 )
 
 type buffer struct {
@@ -71,37 +73,69 @@ func (b *buffer) Clean() {
 
 type pipeline struct {
 	inbuf    *buffer
-	outbuf   *bytes.Buffer
 	showbuf  *bytes.Buffer
 	errbuf   *bytes.Buffer
 	lastLine string
+
+	// stages and activeStage cache each `|`-separated stage of the last
+	// run and track which one's output is currently previewed.
+	stages      []*stageResult
+	activeStage int
+
+	// follow is non-nil in -follow mode, where the shell command is kept
+	// running as stdin grows (and restarted only when the line is edited)
+	// instead of being re-run from a snapshot.
+	follow *follower
 }
 
+// processPipeline re-runs whichever stages of line have changed since the
+// last call and refreshes showbuf from whichever stage is active. It
+// leaves showbuf untouched (and reports an error) when that stage failed,
+// so the display keeps the last good output rather than flashing blank.
 func (p *pipeline) processPipeline(line string) error {
 	p.lastLine = line
-	p.outbuf.Truncate(0)
+	texts := splitStages(line)
+	p.stages = evalStages(p.stages, texts, []byte(p.inbuf.String()))
+
+	if p.activeStage >= len(p.stages) {
+		p.activeStage = len(p.stages) - 1
+	}
+	if p.activeStage < 0 {
+		p.activeStage = 0
+	}
+
+	selected := p.stages[p.activeStage]
 	p.errbuf.Truncate(0)
-	if line == "" {
-		_, err := fmt.Fprint(p.outbuf, p.inbuf.String())
-		p.outbuf, p.showbuf = p.showbuf, p.outbuf
-		return err
+	p.errbuf.Write(selected.err.Bytes())
+	if selected.failed {
+		return fmt.Errorf("stage %d (%q) failed", p.activeStage, selected.text)
+	}
+	p.showbuf.Truncate(0)
+	p.showbuf.Write(selected.out.Bytes())
+	return nil
+}
+
+// tickFollow replaces processPipeline in -follow mode: it restarts the
+// child only when line actually changes, and otherwise just pulls whatever
+// new output the running child has produced into showbuf.
+func (p *pipeline) tickFollow(line string) error {
+	if line != p.lastLine {
+		p.lastLine = line
+		if err := p.follow.restart(line); err != nil {
+			return err
+		}
 	}
-	cmd := exec.Command(*shell, "-c", line)
-	cmd.Stdout = p.outbuf
-	cmd.Stderr = p.errbuf
-	cmd.Stdin = strings.NewReader(p.inbuf.String())
-	err := cmd.Run()
-	log.Printf("%q: %v", line, err)
-	if err == nil {
-		// no error, flip to front
-		p.outbuf, p.showbuf = p.showbuf, p.outbuf
+	if p.follow.out.Dirty() {
+		p.showbuf.Truncate(0)
+		fmt.Fprint(p.showbuf, p.follow.out.String())
+		p.follow.out.Clean()
 	}
-	return err
+	return nil
 }
 
-func (p *pipeline) renderBuffer(b *bytes.Buffer, skipUpper, skipLower, fromEnd int, fg, bg termbox.Attribute) int {
+func (p *pipeline) renderBuffer(b *bytes.Buffer, skipUpper, skipLower, fromEnd int, fg, bg termbox.Attribute, mode viewMode, hscroll int) int {
 	cols, rows := termbox.Size()
-	lines, n := getBufferLinesToShow(rows-skipUpper-skipLower, cols, fromEnd, b.String())
+	lines, n := renderMode(mode, b.Bytes(), rows-skipUpper-skipLower, cols, fromEnd, hscroll)
 	for y, row := range lines {
 		for x, c := range row {
 			termbox.SetCell(x, y+skipUpper, c, fg, bg)
@@ -113,19 +147,78 @@ func (p *pipeline) renderBuffer(b *bytes.Buffer, skipUpper, skipLower, fromEnd i
 	return n
 }
 
-func (p *pipeline) renderLine(line string, cursor int, fg, bg termbox.Attribute) {
-	termbox.SetCell(0, 0, '|', fg, bg)
-	termbox.SetCell(1, 0, ' ', fg, bg)
-	end := 2
+func (p *pipeline) renderLine(row int, prefix, line string, cursor int, fg, bg termbox.Attribute) {
+	offset := 0
+	for _, c := range prefix {
+		termbox.SetCell(offset, row, c, fg, bg)
+		offset++
+	}
+	end := offset
 	for i, c := range line {
-		termbox.SetCell(i+2, 0, c, fg, bg)
+		termbox.SetCell(offset+i, row, c, fg, bg)
 		end++
 	}
 	cols, _ := termbox.Size()
 	for i := end; i < cols; i++ {
-		termbox.SetCell(i, 0, ' ', fg, bg)
+		termbox.SetCell(i, row, ' ', fg, bg)
 	}
-	termbox.SetCursor(2+cursor, 0)
+	termbox.SetCursor(offset+cursor, row)
+}
+
+// renderStageBar draws one labelled cell per pipeline stage on row,
+// highlighting the active stage and flagging any that failed.
+func (p *pipeline) renderStageBar(row int, fg, bg termbox.Attribute) {
+	cols, _ := termbox.Size()
+	x := 0
+	for i, s := range p.stages {
+		cellFg, cellBg := fg, bg
+		if i == p.activeStage {
+			cellFg, cellBg = bg, fg
+		}
+		if s.failed {
+			cellFg = termbox.ColorRed
+		}
+		for _, c := range fmt.Sprintf(" %d ", i+1) {
+			if x >= cols {
+				break
+			}
+			termbox.SetCell(x, row, c, cellFg, cellBg)
+			x++
+		}
+	}
+	for ; x < cols; x++ {
+		termbox.SetCell(x, row, ' ', fg, bg)
+	}
+}
+
+// searchDisplay carries the reverse-i-search overlay to the render goroutine;
+// a nil value means the input line should be rendered normally.
+type searchDisplay struct {
+	query string
+	match string
+	found bool
+}
+
+// promptDisplay carries a filename prompt (Ctrl-S / Ctrl-W) to the render
+// goroutine; a nil value means no prompt is active.
+type promptDisplay struct {
+	label string
+	value string
+}
+
+// appendOncommit records line, timestamped, to the -oncommit file if one was
+// given, so a user can replay an iteratively-built script later.
+func appendOncommit(line string) {
+	if *oncommit == "" {
+		return
+	}
+	f, err := os.OpenFile(*oncommit, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("oncommit: %v", err)
+		return
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "# %s\n%s\n", time.Now().Format(time.RFC3339), line)
 }
 
 func getBufferLinesToShow(rows, cols, skipFromEnd int, data string) ([][]rune, int) {
@@ -205,22 +298,45 @@ func getBufferLinesToShow(rows, cols, skipFromEnd int, data string) ([][]rune, i
 	return lines, skipFromEnd
 }
 
-func (p *pipeline) render(line string, cursor, fromEnd int, processError bool) (error, int) {
+func (p *pipeline) render(line string, cursor, fromEnd int, processError bool, search *searchDisplay, completions []string, prompt *promptDisplay, indicator rune, mode viewMode, hscroll int) (error, int) {
 	_, rows := termbox.Size()
 	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
 	outFg := termbox.ColorDefault
 	if processError {
 		outFg = termbox.ColorYellow
 	}
-	n := p.renderBuffer(p.showbuf, 1, 2, fromEnd, outFg, termbox.ColorDefault)
+	lineRow := 0
+	if len(p.stages) > 1 {
+		p.renderStageBar(0, termbox.ColorDefault, termbox.ColorDefault)
+		lineRow = 1
+	}
+
+	skipUpper := lineRow + 1
+	if len(completions) > 0 {
+		p.renderBuffer(bytes.NewBufferString(strings.Join(completions, "  ")), skipUpper, rows-skipUpper-1, 0, termbox.ColorCyan, termbox.ColorDefault, viewRaw, 0)
+		skipUpper++
+	}
+	n := p.renderBuffer(p.showbuf, skipUpper, 2, fromEnd, outFg, termbox.ColorDefault, mode, hscroll)
 	lineFg, lineBg := termbox.ColorWhite, termbox.ColorBlack
 	if processError {
-		p.renderBuffer(p.errbuf, rows-2, 0, 0, termbox.ColorRed, termbox.ColorBlack)
+		p.renderBuffer(p.errbuf, rows-2, 0, 0, termbox.ColorRed, termbox.ColorBlack, viewRaw, 0)
 		lineFg = termbox.ColorRed
 	} else {
-		p.renderBuffer(bytes.NewBufferString("\n\n\n"), rows-2, 0, 0, termbox.ColorRed, termbox.ColorBlack)
+		p.renderBuffer(bytes.NewBufferString("\n\n\n"), rows-2, 0, 0, termbox.ColorRed, termbox.ColorBlack, viewRaw, 0)
+	}
+	switch {
+	case prompt != nil:
+		p.renderLine(lineRow, prompt.label, prompt.value, len(prompt.value), lineFg, lineBg)
+	case search != nil:
+		failed := ""
+		if !search.found {
+			failed = "failed "
+		}
+		prefix := fmt.Sprintf("(%sreverse-i-search)'%s': ", failed, search.query)
+		p.renderLine(lineRow, prefix, search.match, len(search.match), lineFg, lineBg)
+	default:
+		p.renderLine(lineRow, fmt.Sprintf("%c ", indicator), line, cursor, lineFg, lineBg)
 	}
-	p.renderLine(line, cursor, lineFg, lineBg)
 	return termbox.Flush(), n
 }
 
@@ -247,15 +363,28 @@ func main() {
 	redrawCh := make(chan bool)
 	errorCh := make(chan bool)
 	scrollCh := make(chan int)
+	searchCh := make(chan *searchDisplay)
+	completionCh := make(chan []string)
+	promptCh := make(chan *promptDisplay)
+	stageCh := make(chan int)
+	modeCh := make(chan viewMode)
+	hscrollCh := make(chan int)
+
+	hist := newHistory()
+	completer := newCompleter()
 
 	p := pipeline{
 		inbuf:   &buffer{buffersize: *buffersize},
-		outbuf:  &bytes.Buffer{},
 		showbuf: &bytes.Buffer{},
 		errbuf:  &bytes.Buffer{},
 	}
+	var stdinDst io.Writer = p.inbuf
+	if *follow {
+		p.follow = newFollower(*shell, newLineRing(*followlines))
+		stdinDst = io.MultiWriter(p.inbuf, p.follow)
+	}
 	go func() {
-		io.Copy(p.inbuf, os.Stdin)
+		io.Copy(stdinDst, os.Stdin)
 		log.Print("Done with stdin")
 	}()
 
@@ -276,13 +405,32 @@ func main() {
 		var cursor int
 		var redraw, processError bool
 		var fromEnd int
+		var search *searchDisplay
+		var completions []string
+		var prompt *promptDisplay
+		var tick int
+		var lastStatus followStatus
+		var mode viewMode
+		var hscroll int
 
 		t := time.NewTicker(10 * time.Millisecond)
 		defer t.Stop()
 		for {
 			select {
 			case <-t.C:
-				if line != p.lastLine || p.inbuf.Dirty() {
+				tick++
+				if p.follow != nil {
+					if line != p.lastLine || p.follow.out.Dirty() {
+						if err := p.tickFollow(line); err != nil {
+							log.Printf("follow restart %q: %v", line, err)
+						}
+						redraw = true
+					}
+					if status := p.follow.Status(); status != lastStatus {
+						lastStatus = status
+						redraw = true
+					}
+				} else if line != p.lastLine || p.inbuf.Dirty() {
 					if err := p.processPipeline(line); err != nil {
 						log.Printf("pipeline error: %v", err)
 						processError = true
@@ -294,7 +442,11 @@ func main() {
 				}
 
 				if redraw {
-					if err, n := p.render(line, cursor, fromEnd, processError); err != nil {
+					indicator := '|'
+					if p.follow != nil {
+						indicator = followIndicator(lastStatus, tick)
+					}
+					if err, n := p.render(line, cursor, fromEnd, processError, search, completions, prompt, indicator, mode, hscroll); err != nil {
 						log.Fatalf("Could not write to screen: %v", err)
 					} else {
 						fromEnd = n
@@ -305,6 +457,26 @@ func main() {
 			case cursor = <-cursorCh:
 				redraw = true
 			case redraw = <-redrawCh:
+			case search = <-searchCh:
+				redraw = true
+			case completions = <-completionCh:
+				redraw = true
+			case prompt = <-promptCh:
+				redraw = true
+			case delta := <-stageCh:
+				if n := len(p.stages); n > 0 {
+					p.activeStage = ((p.activeStage+delta)%n + n) % n
+					redraw = true
+				}
+			case mode = <-modeCh:
+				hscroll = 0
+				redraw = true
+			case delta := <-hscrollCh:
+				hscroll += delta
+				if hscroll < 0 {
+					hscroll = 0
+				}
+				redraw = true
 			case <-quit:
 				log.Print("Quitting")
 				errorCh <- processError
@@ -322,6 +494,37 @@ func main() {
 	lineBuffer := ""
 	cursor := 0
 	ebytes := make([]byte, 16)
+	var mode viewMode
+
+	var (
+		searchMode              bool
+		searchQuery, searchLine string
+		searchIdx               int
+		searchFound             bool
+		savedLineBuffer         string
+		savedCursor             int
+	)
+	var comp struct {
+		active     bool
+		matches    []string
+		idx        int
+		start, end int
+	}
+	var (
+		promptMode  bool
+		promptKind  string // "output" or "pipeline"
+		promptLabel string
+		promptBuf   string
+	)
+	research := func() {
+		if m, idx, ok := hist.searchBack(searchQuery, searchIdx); ok {
+			searchLine, searchIdx, searchFound = m, idx, true
+		} else {
+			searchLine, searchFound = savedLineBuffer, searchQuery == ""
+		}
+		searchCh <- &searchDisplay{query: searchQuery, match: searchLine, found: searchFound}
+	}
+
 loop:
 	for {
 		re := termbox.PollRawEvent(ebytes)
@@ -343,7 +546,17 @@ loop:
 			escKey := ebytes[1:re.N]
 			switch string(escKey) {
 			case escEscape:
-				break loop
+				switch {
+				case searchMode:
+					lineBuffer, cursor = savedLineBuffer, savedCursor
+					searchMode = false
+					searchCh <- nil
+				case promptMode:
+					promptMode = false
+					promptCh <- nil
+				default:
+					break loop
+				}
 			case escCtrlLeftArrow:
 				if cursor == 0 {
 					continue
@@ -371,12 +584,139 @@ loop:
 			}
 		}
 
+		if searchMode {
+			switch e.Key {
+			case termbox.KeyCtrlR:
+				searchIdx--
+				research()
+			case termbox.KeyBackspace, termbox.KeyBackspace2:
+				if len(searchQuery) > 0 {
+					searchQuery = searchQuery[:len(searchQuery)-1]
+					searchIdx = len(hist.entries) - 1
+					research()
+				}
+			case termbox.KeyEnter:
+				lineBuffer = searchLine
+				cursor = len(lineBuffer)
+				searchMode = false
+				searchCh <- nil
+			case termbox.KeyCtrlC:
+				break loop
+			case 0:
+				searchQuery += string(e.Ch)
+				searchIdx = len(hist.entries) - 1
+				research()
+			}
+			if e.Width != 0 || e.Height != 0 {
+				redrawCh <- true
+			}
+			lineCh <- lineBuffer
+			cursorCh <- cursor
+			continue
+		}
+
+		if promptMode {
+			switch e.Key {
+			case termbox.KeyEnter:
+				promptMode = false
+				promptCh <- nil
+				var data []byte
+				if promptKind == "output" {
+					data = p.showbuf.Bytes()
+				} else {
+					data = []byte(p.lastLine + "\n")
+				}
+				if err := ioutil.WriteFile(promptBuf, data, 0644); err != nil {
+					log.Printf("save: %v", err)
+				}
+			case termbox.KeyBackspace, termbox.KeyBackspace2:
+				if len(promptBuf) > 0 {
+					promptBuf = promptBuf[:len(promptBuf)-1]
+					promptCh <- &promptDisplay{label: promptLabel, value: promptBuf}
+				}
+			case termbox.KeyCtrlC:
+				break loop
+			case 0:
+				promptBuf += string(e.Ch)
+				promptCh <- &promptDisplay{label: promptLabel, value: promptBuf}
+			}
+			if e.Width != 0 || e.Height != 0 {
+				redrawCh <- true
+			}
+			lineCh <- lineBuffer
+			cursorCh <- cursor
+			continue
+		}
+
+		if comp.active && e.Key != termbox.KeyTab {
+			comp.active = false
+			completionCh <- nil
+		}
+
 		switch e.Key {
 		case termbox.KeyEnter:
+			hist.add(lineBuffer)
+			appendOncommit(lineBuffer)
 			gracefulExit = true
 			break loop
 		case termbox.KeyCtrlC:
 			break loop
+		case termbox.KeyCtrlS:
+			promptMode = true
+			promptKind = "output"
+			promptLabel = "Save output to: "
+			promptBuf = ""
+			promptCh <- &promptDisplay{label: promptLabel, value: promptBuf}
+		case termbox.KeyCtrlW:
+			promptMode = true
+			promptKind = "pipeline"
+			promptLabel = "Write pipeline to: "
+			promptBuf = ""
+			promptCh <- &promptDisplay{label: promptLabel, value: promptBuf}
+		case termbox.KeyCtrlN:
+			if len(splitStages(lineBuffer)) > 1 {
+				stageCh <- 1
+			}
+		case termbox.KeyCtrlP:
+			if len(splitStages(lineBuffer)) > 1 {
+				stageCh <- -1
+			}
+		case termbox.KeyTab:
+			switch {
+			case comp.active:
+				comp.idx = (comp.idx + 1) % len(comp.matches)
+				match := comp.matches[comp.idx]
+				lineBuffer = lineBuffer[:comp.start] + match + lineBuffer[comp.end:]
+				comp.end = comp.start + len(match)
+				cursor = comp.end
+			default:
+				matches, start := completer.complete(lineBuffer, cursor)
+				switch len(matches) {
+				case 0:
+				case 1:
+					lineBuffer = lineBuffer[:start] + matches[0] + lineBuffer[cursor:]
+					cursor = start + len(matches[0])
+				default:
+					comp.active = true
+					comp.matches = matches
+					comp.idx = 0
+					comp.start = start
+					match := matches[0]
+					lineBuffer = lineBuffer[:start] + match + lineBuffer[cursor:]
+					comp.end = start + len(match)
+					cursor = comp.end
+					completionCh <- matches
+				}
+			}
+		case termbox.KeyCtrlV:
+			mode = (mode + 1) % numViewModes
+			modeCh <- mode
+		case termbox.KeyCtrlR:
+			searchMode = true
+			searchQuery = ""
+			searchIdx = len(hist.entries) - 1
+			savedLineBuffer, savedCursor = lineBuffer, cursor
+			research()
 		case termbox.KeySpace:
 			lineBuffer = lineBuffer[:cursor] + string(' ') + lineBuffer[cursor:]
 			cursor++
@@ -386,17 +726,31 @@ loop:
 				cursor--
 			}
 		case termbox.KeyArrowLeft:
-			if cursor > 0 {
+			if mode == viewNoWrap && lineBuffer == "" {
+				hscrollCh <- -1
+			} else if cursor > 0 {
 				cursor--
 			}
 		case termbox.KeyArrowRight:
-			if cursor < len(lineBuffer) {
+			if mode == viewNoWrap && lineBuffer == "" {
+				hscrollCh <- 1
+			} else if cursor < len(lineBuffer) {
 				cursor++
 			}
 		case termbox.KeyArrowDown:
-			scrollCh <- -1
+			if cursor == 0 || lineBuffer == "" {
+				lineBuffer = hist.next(lineBuffer)
+				cursor = len(lineBuffer)
+			} else {
+				scrollCh <- -1
+			}
 		case termbox.KeyArrowUp:
-			scrollCh <- 1
+			if cursor == 0 || lineBuffer == "" {
+				lineBuffer = hist.prev(lineBuffer)
+				cursor = len(lineBuffer)
+			} else {
+				scrollCh <- 1
+			}
 		case termbox.KeyPgdn:
 			_, rows := termbox.Size()
 			scrollCh <- -rows + 4
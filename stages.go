@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+)
+
+// stageResult caches one pipeline stage's last-run text and output, so that
+// editing stage N only re-executes stages >= N instead of the whole
+// pipeline from scratch.
+type stageResult struct {
+	text   string
+	in     []byte
+	out    *bytes.Buffer
+	err    *bytes.Buffer
+	failed bool
+}
+
+// splitStages breaks a pipeline command line into its `|`-separated
+// stages, leaving `||`, `|&`, and any `|` inside single or double quotes
+// alone rather than treating them as stage separators, so common shell
+// idioms (regex alternation, OR chaining) survive unchanged.
+func splitStages(line string) []string {
+	var stages []string
+	var cur strings.Builder
+	var inSingle, inDouble, escaped bool
+	rs := []rune(line)
+	for i := 0; i < len(rs); i++ {
+		c := rs[i]
+		switch {
+		case escaped:
+			escaped = false
+			cur.WriteRune(c)
+		case inSingle:
+			if c == '\'' {
+				inSingle = false
+			}
+			cur.WriteRune(c)
+		case inDouble:
+			if c == '"' {
+				inDouble = false
+			} else if c == '\\' {
+				escaped = true
+			}
+			cur.WriteRune(c)
+		case c == '\\':
+			escaped = true
+			cur.WriteRune(c)
+		case c == '\'':
+			inSingle = true
+			cur.WriteRune(c)
+		case c == '"':
+			inDouble = true
+			cur.WriteRune(c)
+		case c == '|':
+			if i+1 < len(rs) && (rs[i+1] == '|' || rs[i+1] == '&') {
+				cur.WriteRune(c)
+				cur.WriteRune(rs[i+1])
+				i++
+			} else {
+				stages = append(stages, strings.TrimSpace(cur.String()))
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(c)
+		}
+	}
+	stages = append(stages, strings.TrimSpace(cur.String()))
+	return stages
+}
+
+// evalStages re-runs only the stages whose text or stdin differs from the
+// cached run (and everything downstream of the first such stage), feeding
+// each stage's stdin from the previous stage's cached stdout, or in for
+// stage 0 — so growth of in (e.g. more stdin trickling in) invalidates the
+// cache just like an edited stage does.
+func evalStages(cache []*stageResult, stageTexts []string, in []byte) []*stageResult {
+	results := make([]*stageResult, len(stageTexts))
+	changed := false
+	for i, text := range stageTexts {
+		var prev *stageResult
+		if !changed && i < len(cache) {
+			prev = cache[i]
+		}
+		stdin := in
+		if i > 0 {
+			stdin = results[i-1].out.Bytes()
+		}
+		if prev != nil && prev.text == text && bytes.Equal(prev.in, stdin) {
+			results[i] = prev
+			continue
+		}
+		changed = true
+		results[i] = runStage(text, stdin)
+	}
+	return results
+}
+
+func runStage(text string, stdin []byte) *stageResult {
+	r := &stageResult{text: text, in: stdin, out: &bytes.Buffer{}, err: &bytes.Buffer{}}
+	if text == "" {
+		r.out.Write(stdin)
+		return r
+	}
+	cmd := exec.Command(*shell, "-c", text)
+	cmd.Stdout = r.out
+	cmd.Stderr = r.err
+	cmd.Stdin = bytes.NewReader(stdin)
+	if err := cmd.Run(); err != nil {
+		log.Printf("%q: %v", text, err)
+		r.failed = true
+	}
+	return r
+}
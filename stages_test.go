@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStages(t *testing.T) {
+	type c struct {
+		in   string
+		outs []string
+	}
+	for i, tc := range []c{
+		{
+			"grep foo | sort",
+			[]string{"grep foo", "sort"},
+		},
+		{
+			`grep -E "error|warn" file`,
+			[]string{`grep -E "error|warn" file`},
+		},
+		{
+			"foo || bar",
+			[]string{"foo || bar"},
+		},
+		{
+			"foo |& bar",
+			[]string{"foo |& bar"},
+		},
+		{
+			"grep 'a|b' | wc -l",
+			[]string{"grep 'a|b'", "wc -l"},
+		},
+	} {
+		got := splitStages(tc.in)
+		if !reflect.DeepEqual(got, tc.outs) {
+			t.Errorf("Case %d: splitStages(%q) = %q, want %q", i, tc.in, got, tc.outs)
+		}
+	}
+}
+
+func TestEvalStagesCaching(t *testing.T) {
+	cache := evalStages(nil, []string{"", ""}, []byte("hello\n"))
+	if string(cache[1].out.Bytes()) != "hello\n" {
+		t.Fatalf("initial run: got %q, want %q", cache[1].out.Bytes(), "hello\n")
+	}
+
+	again := evalStages(cache, []string{"", ""}, []byte("hello\n"))
+	if again[0] != cache[0] || again[1] != cache[1] {
+		t.Fatalf("unchanged text and stdin should reuse cached results")
+	}
+
+	grown := evalStages(cache, []string{"", ""}, []byte("hello\nworld\n"))
+	if grown[0] == cache[0] {
+		t.Fatalf("growing stdin should invalidate stage 0's cache")
+	}
+	if string(grown[1].out.Bytes()) != "hello\nworld\n" {
+		t.Fatalf("got %q, want %q", grown[1].out.Bytes(), "hello\nworld\n")
+	}
+
+	edited := evalStages(grown, []string{"", "x"}, []byte("hello\nworld\n"))
+	if edited[0] != grown[0] {
+		t.Fatalf("editing stage 1 should not invalidate stage 0's cache")
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// viewMode selects how renderBuffer turns a buffer's bytes into screen
+// lines; Ctrl-V cycles through them.
+type viewMode int
+
+const (
+	viewRaw viewMode = iota
+	viewNoWrap
+	viewHex
+	viewJSON
+	numViewModes
+)
+
+// renderMode dispatches to the renderer for mode, each one producing the
+// lines to show and the fromEnd actually used (for scroll clamping),
+// mirroring getBufferLinesToShow's contract.
+func renderMode(mode viewMode, data []byte, rows, cols, fromEnd, hscroll int) ([][]rune, int) {
+	switch mode {
+	case viewNoWrap:
+		return noWrapLines(data, rows, cols, fromEnd, hscroll)
+	case viewHex:
+		return hexLines(data, rows, cols, fromEnd)
+	case viewJSON:
+		return jsonLines(data, rows, cols, fromEnd)
+	default:
+		return getBufferLinesToShow(rows, cols, fromEnd, string(data))
+	}
+}
+
+// jsonLines pretty-prints data when it parses as JSON, falling back to raw
+// (wrapped) rendering otherwise.
+func jsonLines(data []byte, rows, cols, fromEnd int) ([][]rune, int) {
+	text := string(data)
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err == nil {
+		if pretty, err := json.MarshalIndent(v, "", "  "); err == nil {
+			text = string(pretty)
+		}
+	}
+	return getBufferLinesToShow(rows, cols, fromEnd, text)
+}
+
+// hexLines renders data as xxd-style `offset  hex hex ...  |ascii|` rows,
+// reusing getBufferLinesToShow for the actual scroll clamping.
+func hexLines(data []byte, rows, cols, fromEnd int) ([][]rune, int) {
+	var sb strings.Builder
+	for i := 0; i < len(data); i += 16 {
+		end := i + 16
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := data[i:end]
+		fmt.Fprintf(&sb, "%08x  ", i)
+		for j := 0; j < 16; j++ {
+			if j < len(chunk) {
+				fmt.Fprintf(&sb, "%02x ", chunk[j])
+			} else {
+				sb.WriteString("   ")
+			}
+			if j == 7 {
+				sb.WriteByte(' ')
+			}
+		}
+		sb.WriteString(" |")
+		for _, b := range chunk {
+			if b >= 0x20 && b < 0x7f {
+				sb.WriteByte(b)
+			} else {
+				sb.WriteByte('.')
+			}
+		}
+		sb.WriteString("|\n")
+	}
+	return getBufferLinesToShow(rows, cols, fromEnd, strings.TrimRight(sb.String(), "\n"))
+}
+
+// noWrapLines renders data one screen line per logical line, never
+// wrapping long ones; hscroll instead shifts the whole viewport sideways.
+func noWrapLines(data []byte, rows, cols, fromEnd, hscroll int) ([][]rune, int) {
+	text := strings.TrimRight(string(data), "\n")
+	var all [][]rune
+	if text != "" {
+		for _, l := range strings.Split(text, "\n") {
+			all = append(all, []rune(expandTabsLine(l)))
+		}
+	}
+
+	if fromEnd > len(all)-rows {
+		fromEnd = len(all) - rows
+	}
+	if fromEnd < 0 {
+		fromEnd = 0
+	}
+	end := len(all) - fromEnd
+	start := end - rows
+	if start < 0 {
+		start = 0
+	}
+
+	visible := all[start:end]
+	out := make([][]rune, len(visible))
+	for i, l := range visible {
+		if hscroll < len(l) {
+			l = l[hscroll:]
+		} else {
+			l = nil
+		}
+		if len(l) > cols {
+			l = l[:cols]
+		}
+		out[i] = l
+	}
+	return out, fromEnd
+}
+
+// expandTabsLine expands tabs in a single (newline-free) line, matching
+// getBufferLinesToShow's tab width.
+func expandTabsLine(s string) string {
+	rs := []rune(s)
+	out := make([]rune, 0, 2*len(rs))
+	for _, c := range rs {
+		if c == '\t' {
+			spacesRemaining := TabWidth - len(out)%TabWidth
+			for j := 0; j < spacesRemaining; j++ {
+				out = append(out, ' ')
+			}
+		} else {
+			out = append(out, c)
+		}
+	}
+	return string(out)
+}
@@ -0,0 +1,89 @@
+package main
+
+import "testing"
+
+func TestHexLines(t *testing.T) {
+	type c struct {
+		in   string
+		outs []string
+	}
+	for i, tc := range []c{
+		{
+			"hi",
+			[]string{
+				"00000000  68 69                                             |hi|",
+			},
+		},
+		{
+			"0123456789abcdefgh",
+			[]string{
+				"00000000  30 31 32 33 34 35 36 37  38 39 61 62 63 64 65 66  |0123456789abcdef|",
+				"00000010  67 68                                             |gh|",
+			},
+		},
+	} {
+		rs, _ := hexLines([]byte(tc.in), 3, 80, 0)
+		if err := compareRunePage(rs, tc.outs); err != nil {
+			t.Errorf("Case %d: %v", i, err)
+		}
+	}
+}
+
+func TestJSONLines(t *testing.T) {
+	type c struct {
+		in   string
+		outs []string
+	}
+	for i, tc := range []c{
+		{
+			`{"a":1}`,
+			[]string{
+				"{",
+				`  "a": 1`,
+				"}",
+			},
+		},
+		{
+			"not json",
+			[]string{
+				"not json",
+			},
+		},
+	} {
+		rs, _ := jsonLines([]byte(tc.in), 3, 20, 0)
+		if err := compareRunePage(rs, tc.outs); err != nil {
+			t.Errorf("Case %d: %v", i, err)
+		}
+	}
+}
+
+func TestNoWrapLines(t *testing.T) {
+	type c struct {
+		in      string
+		hscroll int
+		outs    []string
+	}
+	for i, tc := range []c{
+		{
+			"one long line that is past the 20 char limit\nshort",
+			0,
+			[]string{
+				"one long line that i",
+				"short",
+			},
+		},
+		{
+			"one long line that is past the 20 char limit\nshort",
+			4,
+			[]string{
+				"long line that is pa",
+				"t",
+			},
+		},
+	} {
+		rs, _ := noWrapLines([]byte(tc.in), 3, 20, 0, tc.hscroll)
+		if err := compareRunePage(rs, tc.outs); err != nil {
+			t.Errorf("Case %d: %v", i, err)
+		}
+	}
+}